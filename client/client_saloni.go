@@ -1,18 +1,128 @@
 package main
 
 import (
+	"archive/tar"
 	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"file-transfer/crypt"
 	"file-transfer/messages"
+	"file-transfer/ratelimit"
 	"file-transfer/util"
+	hashalgo "file-transfer/util/hash"
+	"flag"
 	"fmt"
+	"hash"
 	"io"
 	"log"
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 )
 
-func put(msgHandler *messages.MessageHandler, fileName string) error {
+// resumeChunkSize is the unit we checkpoint progress at; it must match the
+// block size the server uses when it persists its own .part state so that
+// offsets line up on both ends of a resumed transfer.
+const resumeChunkSize = 1 << 20 // 1 MiB
+
+// encryptTransport and presharedKey configure the optional end-to-end
+// encrypted transport set up by -encrypt/-psk in main; every connection the
+// client opens goes through dialConn so they stay in sync.
+var (
+	encryptTransport bool
+	presharedKey     string
+)
+
+// dialConn opens a TCP connection to host and, if -encrypt was set,
+// negotiates an encrypted transport over it before handing it back.
+func dialConn(host string) (net.Conn, error) {
+	conn, err := net.Dial("tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	if !encryptTransport {
+		return conn, nil
+	}
+	encConn, err := crypt.ClientHandshake(conn, presharedKey)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("encryption handshake: %w", err)
+	}
+	return encConn, nil
+}
+
+// transferLimiter caps transfer bandwidth when -rate is set; nil means
+// unlimited.
+var transferLimiter *ratelimit.Limiter
+
+func rateLimitedReader(r io.Reader) io.Reader {
+	if transferLimiter == nil {
+		return r
+	}
+	return transferLimiter.Reader(r)
+}
+
+func rateLimitedWriter(w io.Writer) io.Writer {
+	if transferLimiter == nil {
+		return w
+	}
+	return transferLimiter.Writer(w)
+}
+
+// partState is the sidecar bookkeeping file kept alongside a file that is
+// only partially transferred. It lets put/get pick back up without
+// re-sending bytes the other side already has.
+type partState struct {
+	Offset    uint64 `json:"offset"`
+	BlockSize uint64 `json:"blockSize"`
+}
+
+func loadPartState(path string) (*partState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ps partState
+	if err := json.Unmarshal(data, &ps); err != nil {
+		return nil, err
+	}
+	return &ps, nil
+}
+
+func (ps *partState) save(path string) error {
+	data, err := json.Marshal(ps)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// rehashPrefix re-derives a hash.Hash's state for the first n bytes of path
+// by reading them back from disk, rather than depending on the algorithm
+// supporting encoding.BinaryMarshaler/BinaryUnmarshaler — BLAKE3 and XXH3
+// don't, so resuming with either of those would otherwise silently fail to
+// restore the checksum and leave the file stuck unresumable forever.
+func rehashPrefix(path string, n uint64, algo hashalgo.Algo) (hash.Hash, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h, err := hashalgo.New(algo)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(h, f, int64(n)); err != nil {
+		return nil, fmt.Errorf("reading first %d bytes of %q: %w", n, path, err)
+	}
+	return h, nil
+}
+
+func put(msgHandler *messages.MessageHandler, fileName string, algo hashalgo.Algo) error {
 	fmt.Println("PUT", fileName)
 
 	info, err := os.Stat(fileName)
@@ -20,9 +130,25 @@ func put(msgHandler *messages.MessageHandler, fileName string) error {
 		return fmt.Errorf("stat failed: %w", err)
 	}
 
-	msgHandler.SendStorageRequest(fileName, uint64(info.Size()))
-	if ok, _ := msgHandler.ReceiveResponse(); !ok {
-		return fmt.Errorf("server rejected storage request")
+	partPath := fileName + ".part"
+
+	// Ask the server what it already has for this file, if anything, before
+	// committing to a full-size StorageRequest.
+	msgHandler.SendResumeRequest(fileName)
+	resumable, resumeOffset, _ := msgHandler.ReceiveResumeResponse()
+
+	h, err := hashalgo.New(algo)
+	if err != nil {
+		return err
+	}
+	var startOffset uint64
+	if resumable && resumeOffset > 0 {
+		if ps, err := loadPartState(partPath); err == nil && ps.Offset == resumeOffset {
+			if primed, err := rehashPrefix(fileName, resumeOffset, algo); err == nil {
+				h = primed
+				startOffset = resumeOffset
+			}
+		}
 	}
 
 	file, err := os.Open(fileName)
@@ -31,10 +157,46 @@ func put(msgHandler *messages.MessageHandler, fileName string) error {
 	}
 	defer file.Close()
 
-	h := md5.New()
-	// Single-pass: hash + send simultaneously using buffered pipeline
-	if _, err := io.Copy(io.MultiWriter(msgHandler, h), file); err != nil {
-		return fmt.Errorf("transfer failed: %w", err)
+	if startOffset > 0 {
+		fmt.Printf("Resuming upload at offset %d of %d\n", startOffset, info.Size())
+		if _, err := file.Seek(int64(startOffset), io.SeekStart); err != nil {
+			return fmt.Errorf("seek to resume offset: %w", err)
+		}
+	}
+
+	msgHandler.SendStorageRequest(fileName, uint64(info.Size()), startOffset, uint32(algo))
+	if ok, _ := msgHandler.ReceiveResponse(); !ok {
+		return fmt.Errorf("server rejected storage request")
+	}
+
+	remaining := uint64(info.Size()) - startOffset
+	sent := startOffset
+	var blockIdx uint32
+	for remaining > 0 {
+		n := uint64(resumeChunkSize)
+		if remaining < n {
+			n = remaining
+		}
+
+		blockHash, err := hashalgo.New(algo)
+		if err != nil {
+			return err
+		}
+		if _, err := ratelimit.CopyNBuffer(io.MultiWriter(rateLimitedWriter(msgHandler), h, blockHash), file, int64(n)); err != nil {
+			return fmt.Errorf("transfer failed: %w", err)
+		}
+		msgHandler.SendBlockChecksum(blockIdx, blockHash.Sum(nil))
+		if ok, _ := msgHandler.ReceiveResponse(); !ok {
+			return fmt.Errorf("server rejected block %d — corrupted in flight", blockIdx)
+		}
+		blockIdx++
+		sent += n
+		remaining -= n
+
+		state := &partState{Offset: sent, BlockSize: resumeChunkSize}
+		// Best-effort checkpoint; a failed write here just means a future
+		// resume falls back to starting over, not a correctness problem.
+		state.save(partPath)
 	}
 
 	msgHandler.SendChecksumVerification(h.Sum(nil))
@@ -42,62 +204,599 @@ func put(msgHandler *messages.MessageHandler, fileName string) error {
 		return fmt.Errorf("checksum verification failed")
 	}
 
+	os.Remove(partPath)
 	fmt.Println("Storage complete!")
 	return nil
 }
 
-func get(msgHandler *messages.MessageHandler, fileName string) error {
+func get(msgHandler *messages.MessageHandler, fileName string, algo hashalgo.Algo) error {
 	fmt.Println("GET", fileName)
 
-	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+	partPath := fileName + ".part"
+
+	var file *os.File
+	h, err := hashalgo.New(algo)
 	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+		return err
+	}
+	var startOffset uint64
+
+	if ps, err := loadPartState(partPath); err == nil {
+		if f, err := os.OpenFile(fileName, os.O_WRONLY, 0666); err == nil {
+			if primed, err := rehashPrefix(fileName, ps.Offset, algo); err == nil {
+				h = primed
+				startOffset = ps.Offset
+				file = f
+			}
+			if file == nil {
+				f.Close()
+			}
+		}
+		if file == nil {
+			// A .part sidecar exists but we couldn't resume from it, so
+			// fileName is a stale partial download rather than unrelated
+			// user data — safe to clear and restart from scratch instead
+			// of hard-failing on O_EXCL below.
+			os.Remove(fileName)
+			os.Remove(partPath)
+		}
+	}
+
+	if file == nil {
+		f, err := os.OpenFile(fileName, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+		if err != nil {
+			return fmt.Errorf("failed to create file: %w", err)
+		}
+		file = f
+		if h, err = hashalgo.New(algo); err != nil {
+			return err
+		}
 	}
 	defer file.Close()
 
-	msgHandler.SendRetrievalRequest(fileName)
+	if startOffset > 0 {
+		fmt.Printf("Resuming download at offset %d\n", startOffset)
+		if _, err := file.Seek(int64(startOffset), io.SeekStart); err != nil {
+			return fmt.Errorf("seek to resume offset: %w", err)
+		}
+	}
+
+	msgHandler.SendRetrievalRequest(fileName, startOffset, uint32(algo))
 	ok, _, size := msgHandler.ReceiveRetrievalResponse()
 	if !ok {
 		return fmt.Errorf("server rejected retrieval request")
 	}
 
-	h := md5.New()
-	// Write to disk and hash in one pass; avoids re-reading file for checksum
-	if _, err := io.CopyN(io.MultiWriter(file, h), msgHandler, int64(size)); err != nil {
-		return fmt.Errorf("transfer failed: %w", err)
+	remaining := size - startOffset
+	received := startOffset
+	var blockIdx uint32
+	for remaining > 0 {
+		n := uint64(resumeChunkSize)
+		if remaining < n {
+			n = remaining
+		}
+
+		blockHash, err := hashalgo.New(algo)
+		if err != nil {
+			return err
+		}
+		if _, err := ratelimit.CopyNBuffer(io.MultiWriter(file, h, blockHash), rateLimitedReader(msgHandler), int64(n)); err != nil {
+			return fmt.Errorf("transfer failed: %w", err)
+		}
+
+		idx, serverBlockSum, err := msgHandler.ReceiveBlockChecksum()
+		if err != nil {
+			return fmt.Errorf("receiving block checksum: %w", err)
+		}
+		if !util.VerifyChecksum(blockHash.Sum(nil), serverBlockSum) {
+			os.Remove(fileName)
+			os.Remove(partPath)
+			return fmt.Errorf("block %d failed checksum verification — corrupt transfer, file removed", idx)
+		}
+		blockIdx++
+		received += n
+		remaining -= n
+
+		state := &partState{Offset: received, BlockSize: resumeChunkSize}
+		state.save(partPath)
 	}
 
 	checkMsg, _ := msgHandler.Receive()
 	serverCheck := checkMsg.GetChecksum().Checksum
 
 	if util.VerifyChecksum(serverCheck, h.Sum(nil)) {
+		os.Remove(partPath)
 		log.Println("Successfully retrieved file.")
 	} else {
 		// Remove corrupt file to avoid leaving garbage on disk
 		os.Remove(fileName)
+		os.Remove(partPath)
 		return fmt.Errorf("checksum mismatch — corrupt transfer, file removed")
 	}
 
 	return nil
 }
 
+// newTransferID returns a random hex identifier the server can use to group
+// the independent connections of a single multi-stream transfer.
+func newTransferID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand failing is effectively unrecoverable; fall back to a
+		// fixed-looking ID is unsafe, so just surface it loudly instead.
+		log.Fatalf("generating transfer ID: %v\n", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// clampStreams caps numStreams so every stream gets at least one byte —
+// dividing size bytes across more streams than that would hand most of them
+// a zero-length range, which wastes connections on the PUT side and, on the
+// GET side, desyncs the protocol entirely (a zero-length ranged request
+// isn't recognized as a range by the server). size == 0 still gets one
+// stream, which transfers nothing.
+func clampStreams(numStreams int, size uint64) int {
+	if numStreams < 1 {
+		return 1
+	}
+	if size == 0 {
+		return 1
+	}
+	if uint64(numStreams) > size {
+		return int(size)
+	}
+	return numStreams
+}
+
+// putParallel splits fileName into numStreams contiguous byte ranges and
+// uploads each over its own TCP connection concurrently. Once every stream
+// has landed, the server hashes the whole reassembled file and each stream
+// relays that checksum back here for comparison against fileSum, so a
+// corrupt reassembly (not just a corrupt individual range) is caught.
+func putParallel(host, fileName string, numStreams int) error {
+	fmt.Println("PUT", fileName, "(", numStreams, "streams )")
+
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return fmt.Errorf("stat failed: %w", err)
+	}
+	size := uint64(info.Size())
+	transferID := newTransferID()
+
+	fileSum, err := fileMD5(fileName)
+	if err != nil {
+		return fmt.Errorf("hashing %q: %w", fileName, err)
+	}
+
+	numStreams = clampStreams(numStreams, size)
+	streamSize := size / uint64(numStreams)
+	var wg sync.WaitGroup
+	errCh := make(chan error, numStreams)
+	for i := 0; i < numStreams; i++ {
+		offset := uint64(i) * streamSize
+		length := streamSize
+		if i == numStreams-1 {
+			length = size - offset // last stream absorbs the remainder
+		}
+		wg.Add(1)
+		go func(idx int, offset, length uint64) {
+			defer wg.Done()
+			if err := putStream(host, fileName, transferID, size, numStreams, idx, offset, length, fileSum); err != nil {
+				errCh <- fmt.Errorf("stream %d: %w", idx, err)
+			}
+		}(i, offset, length)
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("Storage complete!")
+	return nil
+}
+
+func putStream(host, fileName, transferID string, size uint64, numStreams, idx int, offset, length uint64, fileSum []byte) error {
+	conn, err := dialConn(host)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+	msgHandler := messages.NewMessageHandler(conn)
+
+	msgHandler.SendStreamAssignment(transferID, fileName, size, uint64(numStreams), idx, offset, length)
+	if ok, _ := msgHandler.ReceiveResponse(); !ok {
+		return fmt.Errorf("server rejected stream assignment")
+	}
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(int64(offset), io.SeekStart); err != nil {
+		return fmt.Errorf("seek: %w", err)
+	}
+
+	h := md5.New()
+	if _, err := ratelimit.CopyNBuffer(io.MultiWriter(rateLimitedWriter(msgHandler), h), file, int64(length)); err != nil {
+		return fmt.Errorf("sending range: %w", err)
+	}
+	msgHandler.SendBlockChecksum(uint32(idx), h.Sum(nil))
+
+	if ok, _ := msgHandler.ReceiveResponse(); !ok {
+		return fmt.Errorf("server rejected stream data")
+	}
+
+	// Every stream blocks here until the rest have landed: the server only
+	// sends this once it has hashed the complete, reassembled file.
+	checkMsg, err := msgHandler.Receive()
+	if err != nil {
+		return fmt.Errorf("receiving whole-transfer checksum: %w", err)
+	}
+	if !util.VerifyChecksum(checkMsg.GetChecksum().Checksum, fileSum) {
+		return fmt.Errorf("whole-transfer checksum mismatch — corrupt upload")
+	}
+	return nil
+}
+
+// getParallel downloads fileName over numStreams concurrent connections,
+// each writing its assigned byte range directly into the preallocated
+// destination file with WriteAt.
+func getParallel(host, fileName string, numStreams int) error {
+	fmt.Println("GET", fileName, "(", numStreams, "streams )")
+
+	probeConn, err := dialConn(host)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	probe := messages.NewMessageHandler(probeConn)
+	probe.SendRetrievalRequest(fileName, 0, 0)
+	ok, _, size := probe.ReceiveRetrievalResponse()
+	probeConn.Close()
+	if !ok {
+		return fmt.Errorf("server rejected retrieval request")
+	}
+
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	if err := file.Truncate(int64(size)); err != nil {
+		file.Close()
+		return fmt.Errorf("preallocating %q: %w", fileName, err)
+	}
+	file.Close()
+
+	if size == 0 {
+		// An empty file: no range to request, and a zero-length ranged
+		// retrieval isn't a request handleRetrieval recognizes as a range.
+		log.Println("Successfully retrieved file.")
+		return nil
+	}
+
+	numStreams = clampStreams(numStreams, size)
+	streamSize := size / uint64(numStreams)
+	var wg sync.WaitGroup
+	errCh := make(chan error, numStreams)
+	for i := 0; i < numStreams; i++ {
+		offset := uint64(i) * streamSize
+		length := streamSize
+		if i == numStreams-1 {
+			length = size - offset
+		}
+		wg.Add(1)
+		go func(idx int, offset, length uint64) {
+			defer wg.Done()
+			if err := getStream(host, fileName, offset, length); err != nil {
+				errCh <- fmt.Errorf("stream %d: %w", idx, err)
+			}
+		}(i, offset, length)
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Println("Successfully retrieved file.")
+	return nil
+}
+
+func getStream(host, fileName string, offset, length uint64) error {
+	conn, err := dialConn(host)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+	msgHandler := messages.NewMessageHandler(conn)
+
+	msgHandler.SendRangedRetrievalRequest(fileName, offset, length)
+	if ok, _, _ := msgHandler.ReceiveRetrievalResponse(); !ok {
+		return fmt.Errorf("server rejected ranged retrieval")
+	}
+
+	file, err := os.OpenFile(fileName, os.O_WRONLY, 0666)
+	if err != nil {
+		return fmt.Errorf("open %q: %w", fileName, err)
+	}
+	defer file.Close()
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(msgHandler, buf); err != nil {
+		return fmt.Errorf("receiving range: %w", err)
+	}
+
+	rangeSum := md5.Sum(buf)
+	_, serverSum, err := msgHandler.ReceiveBlockChecksum()
+	if err != nil {
+		return fmt.Errorf("receiving range checksum: %w", err)
+	}
+	if !util.VerifyChecksum(rangeSum[:], serverSum) {
+		return fmt.Errorf("range [%d,%d) of %q failed checksum verification", offset, offset+length, fileName)
+	}
+
+	if _, err := file.WriteAt(buf, int64(offset)); err != nil {
+		return fmt.Errorf("writing range: %w", err)
+	}
+	return nil
+}
+
+// sanitizeTarPath joins a tar entry name onto destDir, rejecting absolute
+// paths and ".." components so a malicious or corrupt archive can't write
+// outside the destination.
+func sanitizeTarPath(destDir, name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return "", fmt.Errorf("unsafe absolute path in archive: %q", name)
+	}
+	clean := filepath.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("unsafe path in archive: %q", name)
+	}
+	return filepath.Join(destDir, clean), nil
+}
+
+// fileMD5 hashes a single file on disk; used to annotate each tar entry
+// with a per-file checksum the other side can verify independently.
+func fileMD5(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := ratelimit.CopyBuffer(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// writeTar walks root and writes its contents to tw, rooted under
+// filepath.Base(root) so the receiver extracts a single top-level
+// directory. Every regular file's MD5 is recorded in its PAX header.
+func writeTar(tw *tar.Writer, root string) error {
+	rootName := filepath.Base(root)
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			header.Name = rootName + "/"
+		} else {
+			header.Name = filepath.ToSlash(filepath.Join(rootName, rel))
+			if info.IsDir() {
+				header.Name += "/"
+			}
+		}
+
+		if info.Mode().IsRegular() {
+			sum, err := fileMD5(path)
+			if err != nil {
+				return err
+			}
+			header.PAXRecords = map[string]string{"file-transfer.md5": hex.EncodeToString(sum)}
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err := ratelimit.CopyBuffer(tw, f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// countingWriter discards everything written to it, counting the bytes;
+// used to size a tar stream before committing to the DirectoryStorageRequest.
+type countingWriter struct{ n uint64 }
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += uint64(len(p))
+	return len(p), nil
+}
+
+func tarSize(root string) (uint64, error) {
+	var counter countingWriter
+	tw := tar.NewWriter(&counter)
+	if err := writeTar(tw, root); err != nil {
+		return 0, err
+	}
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+	return counter.n, nil
+}
+
+// putDirectory streams root as a tar archive to the server, which extracts
+// it under its storage directory.
+func putDirectory(host, root string) error {
+	fmt.Println("PUT", root, "(directory)")
+
+	size, err := tarSize(root)
+	if err != nil {
+		return fmt.Errorf("sizing directory: %w", err)
+	}
+
+	conn, err := dialConn(host)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+	msgHandler := messages.NewMessageHandler(conn)
+
+	msgHandler.SendDirectoryStorageRequest(filepath.Base(root), size)
+	if ok, _ := msgHandler.ReceiveResponse(); !ok {
+		return fmt.Errorf("server rejected directory storage request")
+	}
+
+	tw := tar.NewWriter(msgHandler)
+	if err := writeTar(tw, root); err != nil {
+		return fmt.Errorf("streaming directory: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("closing tar stream: %w", err)
+	}
+
+	if ok, _ := msgHandler.ReceiveResponse(); !ok {
+		return fmt.Errorf("server reported a directory transfer error")
+	}
+
+	fmt.Println("Directory stored!")
+	return nil
+}
+
+// getDirectory asks the server for dirName (a directory on the server) and
+// extracts the tar stream it sends back under the current directory,
+// verifying each regular file against the per-entry MD5 in its PAX header.
+func getDirectory(host, dirName string) error {
+	dirName = strings.TrimSuffix(dirName, "/")
+	fmt.Println("GET", dirName, "(directory)")
+
+	conn, err := dialConn(host)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+	msgHandler := messages.NewMessageHandler(conn)
+
+	msgHandler.SendDirectoryRetrievalRequest(dirName)
+	ok, _, size := msgHandler.ReceiveDirectoryRetrievalResponse()
+	if !ok {
+		return fmt.Errorf("server rejected directory retrieval request")
+	}
+
+	tr := tar.NewReader(io.LimitReader(msgHandler, int64(size)))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading tar stream: %w", err)
+		}
+
+		target, err := sanitizeTarPath(".", header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+			if err != nil {
+				return err
+			}
+			h := md5.New()
+			_, copyErr := ratelimit.CopyBuffer(io.MultiWriter(f, h), tr)
+			f.Close()
+			if copyErr != nil {
+				return fmt.Errorf("writing %q: %w", target, copyErr)
+			}
+			if want, ok := header.PAXRecords["file-transfer.md5"]; ok && hex.EncodeToString(h.Sum(nil)) != want {
+				return fmt.Errorf("checksum mismatch for %q — corrupt transfer", header.Name)
+			}
+		}
+	}
+
+	log.Println("Successfully retrieved directory.")
+	return nil
+}
+
 func main() {
-	if len(os.Args) < 4 {
-		fmt.Fprintf(os.Stderr, "Usage: %s server:port put|get file-name [download-dir]\n", os.Args[0])
+	streams := flag.Int("streams", 1, "number of parallel connections to use for the transfer")
+	hashName := flag.String("hash", "md5", "checksum algorithm to use: md5, sha256, blake3, or xxh3")
+	encrypt := flag.Bool("encrypt", false, "negotiate an end-to-end encrypted transport with the server")
+	psk := flag.String("psk", "", "pre-shared key mixed into the encrypted handshake to resist MITM (requires -encrypt)")
+	rateFlag := flag.String("rate", "", "cap transfer bandwidth, e.g. 10MB or 500KB (bytes/sec; unlimited if empty)")
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) < 3 {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-streams N] [-hash algo] [-encrypt] [-psk key] [-rate bytes/sec] server:port put|get file-name [download-dir]\n", os.Args[0])
 		os.Exit(1)
 	}
 
-	host := os.Args[1]
-	action := strings.ToLower(os.Args[2])
-	fileName := os.Args[3]
+	encryptTransport = *encrypt
+	presharedKey = *psk
+	if presharedKey != "" && !encryptTransport {
+		log.Fatalf("-psk requires -encrypt\n")
+	}
+	if *rateFlag != "" {
+		bytesPerSec, err := ratelimit.ParseRate(*rateFlag)
+		if err != nil {
+			log.Fatalf("Invalid -rate value: %v\n", err)
+		}
+		transferLimiter = ratelimit.NewLimiter(bytesPerSec)
+	}
+
+	host := args[0]
+	action := strings.ToLower(args[1])
+	fileName := args[2]
 
 	if action != "put" && action != "get" {
 		log.Fatalf("Invalid action %q — must be 'put' or 'get'\n", action)
 	}
+	if *streams < 1 {
+		log.Fatalf("Invalid -streams value %d — must be at least 1\n", *streams)
+	}
+	algo, err := hashalgo.ParseAlgo(*hashName)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
 
 	// Validate optional download directory upfront
-	if len(os.Args) >= 5 {
-		dir := os.Args[4]
+	if len(args) >= 4 {
+		dir := args[3]
 		if _, err := os.Stat(dir); err != nil {
 			log.Fatalf("Invalid download directory %q: %v\n", dir, err)
 		}
@@ -107,23 +806,46 @@ func main() {
 		}
 	}
 
-	conn, err := net.Dial("tcp", host)
-	if err != nil {
-		log.Fatalf("Connection failed: %v\n", err)
+	if action == "put" {
+		if info, err := os.Stat(fileName); err == nil && info.IsDir() {
+			if err := putDirectory(host, fileName); err != nil {
+				log.Fatalf("Operation failed: %v\n", err)
+			}
+			return
+		}
+	}
+	if action == "get" && strings.HasSuffix(fileName, "/") {
+		if err := getDirectory(host, fileName); err != nil {
+			log.Fatalf("Operation failed: %v\n", err)
+		}
+		return
 	}
-	defer conn.Close()
-
-	msgHandler := messages.NewMessageHandler(conn)
 
 	var opErr error
-	switch action {
-	case "put":
-		opErr = put(msgHandler, fileName)
-	case "get":
-		opErr = get(msgHandler, fileName)
+	if *streams > 1 {
+		switch action {
+		case "put":
+			opErr = putParallel(host, fileName, *streams)
+		case "get":
+			opErr = getParallel(host, fileName, *streams)
+		}
+	} else {
+		conn, err := dialConn(host)
+		if err != nil {
+			log.Fatalf("Connection failed: %v\n", err)
+		}
+		defer conn.Close()
+
+		msgHandler := messages.NewMessageHandler(conn)
+		switch action {
+		case "put":
+			opErr = put(msgHandler, fileName, algo)
+		case "get":
+			opErr = get(msgHandler, fileName, algo)
+		}
 	}
 
 	if opErr != nil {
 		log.Fatalf("Operation failed: %v\n", opErr)
 	}
-}
\ No newline at end of file
+}
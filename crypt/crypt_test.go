@@ -0,0 +1,107 @@
+package crypt
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestDeriveKeysAgreeBothDirections(t *testing.T) {
+	serverPub, serverPriv, err := newKeyPair()
+	if err != nil {
+		t.Fatalf("newKeyPair (server): %v", err)
+	}
+	clientPub, clientPriv, err := newKeyPair()
+	if err != nil {
+		t.Fatalf("newKeyPair (client): %v", err)
+	}
+	salt, err := randomSalt()
+	if err != nil {
+		t.Fatalf("randomSalt: %v", err)
+	}
+
+	clientToServer1, serverToClient1, err := deriveKeys(clientPriv, serverPub, salt, "shared-secret")
+	if err != nil {
+		t.Fatalf("deriveKeys (client side): %v", err)
+	}
+	clientToServer2, serverToClient2, err := deriveKeys(serverPriv, clientPub, salt, "shared-secret")
+	if err != nil {
+		t.Fatalf("deriveKeys (server side): %v", err)
+	}
+
+	if clientToServer1 != clientToServer2 {
+		t.Errorf("client->server keys disagree between the two sides of the handshake")
+	}
+	if serverToClient1 != serverToClient2 {
+		t.Errorf("server->client keys disagree between the two sides of the handshake")
+	}
+	if clientToServer1 == serverToClient1 {
+		t.Errorf("the two directional keys must not be equal")
+	}
+}
+
+func TestHandshakeRoundTrip(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	errCh := make(chan error, 1)
+	var encServer net.Conn
+	go func() {
+		var err error
+		encServer, err = ServerHandshake(serverConn, "shared-secret")
+		errCh <- err
+	}()
+
+	encClient, err := ClientHandshake(clientConn, "shared-secret")
+	if err != nil {
+		t.Fatalf("ClientHandshake: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("ServerHandshake: %v", err)
+	}
+
+	want := []byte("hello over an encrypted transport")
+	go func() {
+		if _, err := encClient.Write(want); err != nil {
+			t.Errorf("client write: %v", err)
+		}
+	}()
+
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(encServer, got); err != nil {
+		t.Fatalf("server read: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("server received %q, want %q", got, want)
+	}
+}
+
+func TestHandshakeWrongPSKFailsToCommunicate(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	errCh := make(chan error, 1)
+	var encServer net.Conn
+	go func() {
+		var err error
+		encServer, err = ServerHandshake(serverConn, "server-secret")
+		errCh <- err
+	}()
+
+	encClient, err := ClientHandshake(clientConn, "client-secret")
+	if err != nil {
+		t.Fatalf("ClientHandshake: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("ServerHandshake: %v", err)
+	}
+
+	go encClient.Write([]byte("hello"))
+
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(encServer, buf); err == nil {
+		t.Fatalf("expected a decryption error with mismatched PSKs, got nil")
+	}
+}
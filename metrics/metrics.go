@@ -0,0 +1,147 @@
+// Package metrics exposes transfer activity as Prometheus counters and,
+// optionally, StatsD datagrams, so an operator can watch a running server
+// without grepping its logs.
+package metrics
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	BytesReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "file_transfer_bytes_received_total",
+		Help: "Total bytes read from client connections.",
+	})
+	BytesSent = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "file_transfer_bytes_sent_total",
+		Help: "Total bytes written to client connections.",
+	})
+	ActiveConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "file_transfer_active_connections",
+		Help: "Number of client connections currently being handled.",
+	})
+	TransfersTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "file_transfer_transfers_total",
+		Help: "Completed transfers, labeled by operation and outcome.",
+	}, []string{"operation", "outcome"})
+	TransferDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "file_transfer_duration_seconds",
+		Help: "Wall-clock time spent serving a single storage or retrieval request.",
+	})
+	TransferSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "file_transfer_transfer_size_bytes",
+		Help:    "Size of completed storage and retrieval transfers, in bytes.",
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 10),
+	})
+	ChecksumFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "file_transfer_checksum_failures_total",
+		Help: "Checksum mismatches detected, labeled by operation.",
+	}, []string{"operation"})
+)
+
+// ServeHTTP starts a Prometheus /metrics endpoint on addr in the background.
+// It never blocks the caller; a failure to bind is logged rather than fatal,
+// since metrics are observability, not core functionality.
+func ServeHTTP(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("metrics: HTTP server on %s stopped: %v", addr, err)
+		}
+	}()
+	log.Printf("Serving Prometheus metrics on %s/metrics", addr)
+}
+
+// StatsDClient emits counters and timings to a StatsD daemon over UDP using
+// the plaintext wire protocol (no ack, best-effort).
+type StatsDClient struct {
+	conn   net.Conn
+	prefix string
+}
+
+// NewStatsDClient dials addr (host:port) and returns a client that prefixes
+// every metric name with prefix (use "" for none).
+func NewStatsDClient(addr, prefix string) (*StatsDClient, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial statsd at %s: %w", addr, err)
+	}
+	return &StatsDClient{conn: conn, prefix: prefix}, nil
+}
+
+func (c *StatsDClient) name(metric string) string {
+	if c.prefix == "" {
+		return metric
+	}
+	return c.prefix + "." + metric
+}
+
+// Count sends a counter increment/decrement.
+func (c *StatsDClient) Count(metric string, delta int64) {
+	c.send(fmt.Sprintf("%s:%d|c", c.name(metric), delta))
+}
+
+// Timing sends a duration in milliseconds.
+func (c *StatsDClient) Timing(metric string, d time.Duration) {
+	c.send(fmt.Sprintf("%s:%d|ms", c.name(metric), d.Milliseconds()))
+}
+
+// Gauge sends an absolute gauge value.
+func (c *StatsDClient) Gauge(metric string, value int64) {
+	c.send(fmt.Sprintf("%s:%d|g", c.name(metric), value))
+}
+
+func (c *StatsDClient) send(packet string) {
+	if _, err := c.conn.Write([]byte(packet)); err != nil {
+		log.Printf("statsd: write failed: %v", err)
+	}
+}
+
+func (c *StatsDClient) Close() error {
+	return c.conn.Close()
+}
+
+// connWrapper wraps a net.Conn so every byte read or written is reflected in
+// the BytesReceived/BytesSent counters above, and optionally mirrored to a
+// StatsD daemon.
+type connWrapper struct {
+	net.Conn
+	statsd *StatsDClient
+}
+
+// WrapConn returns conn instrumented with byte counters. statsd may be nil,
+// in which case only the Prometheus counters are updated.
+func WrapConn(conn net.Conn, statsd *StatsDClient) net.Conn {
+	return &connWrapper{Conn: conn, statsd: statsd}
+}
+
+func (w *connWrapper) Read(p []byte) (int, error) {
+	n, err := w.Conn.Read(p)
+	if n > 0 {
+		BytesReceived.Add(float64(n))
+		if w.statsd != nil {
+			w.statsd.Count("bytes_received", int64(n))
+		}
+	}
+	return n, err
+}
+
+func (w *connWrapper) Write(p []byte) (int, error) {
+	n, err := w.Conn.Write(p)
+	if n > 0 {
+		BytesSent.Add(float64(n))
+		if w.statsd != nil {
+			w.statsd.Count("bytes_sent", int64(n))
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,160 @@
+// Package ratelimit throttles transfer bandwidth to a configured byte rate
+// and pools the buffers used to copy data between connections and files, so
+// many concurrent large transfers don't each pay for a fresh allocation.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+const copyBufferSize = 32 * 1024
+
+var bufferPool = sync.Pool{
+	New: func() interface{} { return make([]byte, copyBufferSize) },
+}
+
+// CopyBuffer is io.CopyBuffer backed by a pooled buffer.
+func CopyBuffer(dst io.Writer, src io.Reader) (int64, error) {
+	buf := bufferPool.Get().([]byte)
+	defer bufferPool.Put(buf)
+	return io.CopyBuffer(dst, src, buf)
+}
+
+// CopyNBuffer is io.CopyN backed by a pooled buffer, for the per-block
+// transfer loops that need to copy a fixed number of bytes per call instead
+// of draining src to EOF.
+func CopyNBuffer(dst io.Writer, src io.Reader, n int64) (int64, error) {
+	written, err := CopyBuffer(dst, io.LimitReader(src, n))
+	if written == n {
+		return written, nil
+	}
+	if err == nil {
+		err = io.EOF
+	}
+	return written, err
+}
+
+// Limiter is a token-bucket cap on bytes/sec, shared by wrapping it around
+// as many Readers and Writers as should draw from the same budget.
+type Limiter struct {
+	limiter *rate.Limiter
+}
+
+// NewLimiter returns a Limiter capped at bytesPerSec, bursting up to one
+// second's worth of traffic. bytesPerSec is floored at 1: a zero or negative
+// burst would make waitN's chunk size permanently 0, spinning forever
+// instead of ever blocking.
+func NewLimiter(bytesPerSec int64) *Limiter {
+	if bytesPerSec < 1 {
+		bytesPerSec = 1
+	}
+	return &Limiter{limiter: rate.NewLimiter(rate.Limit(bytesPerSec), int(bytesPerSec))}
+}
+
+// Reader returns r wrapped so reads block until the limiter has budget for
+// the bytes returned.
+func (l *Limiter) Reader(r io.Reader) io.Reader {
+	return &limitedReader{r: r, limiter: l.limiter}
+}
+
+// Writer returns w wrapped so writes block until the limiter has budget for
+// the bytes being written.
+func (l *Limiter) Writer(w io.Writer) io.Writer {
+	return &limitedWriter{w: w, limiter: l.limiter}
+}
+
+type limitedReader struct {
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	if n > 0 {
+		if waitErr := waitN(l.limiter, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+type limitedWriter struct {
+	w       io.Writer
+	limiter *rate.Limiter
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if err := waitN(l.limiter, len(p)); err != nil {
+		return 0, err
+	}
+	return l.w.Write(p)
+}
+
+// waitN reserves n tokens, splitting the request if n exceeds the limiter's
+// burst size (rate.Limiter.WaitN rejects requests larger than its burst).
+func waitN(limiter *rate.Limiter, n int) error {
+	burst := limiter.Burst()
+	for n > 0 {
+		chunk := n
+		if chunk > burst {
+			chunk = burst
+		}
+		if err := limiter.WaitN(context.Background(), chunk); err != nil {
+			return fmt.Errorf("rate limiter: %w", err)
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// ParseRate parses a human-readable byte rate like "10MB", "500KB", or a
+// bare byte count, returning bytes/sec. Units are decimal (1KB == 1000
+// bytes), matching how operators typically quote network bandwidth.
+func ParseRate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("empty rate")
+	}
+
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"GB", 1_000_000_000},
+		{"MB", 1_000_000},
+		{"KB", 1_000},
+		{"B", 1},
+	}
+
+	upper := strings.ToUpper(s)
+	for _, u := range units {
+		if strings.HasSuffix(upper, u.suffix) {
+			numPart := strings.TrimSpace(s[:len(s)-len(u.suffix)])
+			value, err := strconv.ParseFloat(numPart, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid rate %q: %w", s, err)
+			}
+			bytesPerSec := int64(value * float64(u.multiplier))
+			if bytesPerSec < 1 {
+				return 0, fmt.Errorf("invalid rate %q: must be positive", s)
+			}
+			return bytesPerSec, nil
+		}
+	}
+
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q: %w", s, err)
+	}
+	if value < 1 {
+		return 0, fmt.Errorf("invalid rate %q: must be positive", s)
+	}
+	return value, nil
+}
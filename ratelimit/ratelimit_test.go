@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"io"
+	"testing"
+)
+
+func TestParseRate(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"1000", 1000, false},
+		{"10MB", 10_000_000, false},
+		{"500KB", 500_000, false},
+		{"2GB", 2_000_000_000, false},
+		{"1.5MB", 1_500_000, false},
+		{"100B", 100, false},
+		{"", 0, true},
+		{"notarate", 0, true},
+		{"MB", 0, true},
+		{"0B", 0, true},
+		{"0", 0, true},
+		{"-1", 0, true},
+		{"0.0001B", 0, true},
+	}
+
+	for _, c := range cases {
+		got, err := ParseRate(c.in)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("ParseRate(%q): expected error, got %d", c.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseRate(%q): unexpected error: %v", c.in, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("ParseRate(%q) = %d, want %d", c.in, got, c.want)
+		}
+	}
+}
+
+func TestNewLimiterFloorsZeroBurst(t *testing.T) {
+	// A zero-burst limiter would make waitN's chunk size permanently 0,
+	// spinning forever instead of ever blocking.
+	l := NewLimiter(0)
+	if burst := l.limiter.Burst(); burst < 1 {
+		t.Fatalf("NewLimiter(0).limiter.Burst() = %d, want >= 1", burst)
+	}
+}
+
+func TestCopyNBuffer(t *testing.T) {
+	src := []byte("hello, world")
+	var dst []byte
+	buf := &writerFunc{write: func(p []byte) (int, error) {
+		dst = append(dst, p...)
+		return len(p), nil
+	}}
+
+	n, err := CopyNBuffer(buf, &sliceReader{data: src}, int64(len(src)))
+	if err != nil {
+		t.Fatalf("CopyNBuffer: unexpected error: %v", err)
+	}
+	if n != int64(len(src)) {
+		t.Fatalf("CopyNBuffer: copied %d bytes, want %d", n, len(src))
+	}
+	if string(dst) != string(src) {
+		t.Fatalf("CopyNBuffer: copied %q, want %q", dst, src)
+	}
+}
+
+type sliceReader struct{ data []byte }
+
+func (r *sliceReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+type writerFunc struct {
+	write func([]byte) (int, error)
+}
+
+func (w *writerFunc) Write(p []byte) (int, error) { return w.write(p) }
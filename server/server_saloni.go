@@ -1,55 +1,277 @@
 package main
 
 import (
+	"archive/tar"
 	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"file-transfer/crypt"
 	"file-transfer/messages"
+	"file-transfer/metrics"
+	"file-transfer/ratelimit"
+	"file-transfer/transfer"
 	"file-transfer/util"
+	hashalgo "file-transfer/util/hash"
+	"flag"
 	"fmt"
+	"hash"
 	"io"
 	"log"
 	"net"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
-func handleStorage(msgHandler *messages.MessageHandler, request *messages.StorageRequest) error {
-	log.Printf("Storing %q (%d bytes) from %s", request.FileName, request.Size, msgHandler.RemoteAddr())
+// statsd is the optional StatsD client configured by -statsd; nil if unset.
+var statsd *metrics.StatsDClient
 
-	file, err := os.OpenFile(request.FileName, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+// globalLimiter caps total transfer bandwidth across every connection when
+// -rate is set; nil means unlimited. connRateBytesPerSec additionally caps
+// each individual connection when -conn-rate is set.
+var (
+	globalLimiter       *ratelimit.Limiter
+	connRateBytesPerSec int64
+)
+
+// rateLimitedReader wraps base with the global limiter and, if connLimiter
+// is non-nil, that connection's own sub-limiter too.
+func rateLimitedReader(base io.Reader, connLimiter *ratelimit.Limiter) io.Reader {
+	r := base
+	if globalLimiter != nil {
+		r = globalLimiter.Reader(r)
+	}
+	if connLimiter != nil {
+		r = connLimiter.Reader(r)
+	}
+	return r
+}
+
+func rateLimitedWriter(base io.Writer, connLimiter *ratelimit.Limiter) io.Writer {
+	w := base
+	if globalLimiter != nil {
+		w = globalLimiter.Writer(w)
+	}
+	if connLimiter != nil {
+		w = connLimiter.Writer(w)
+	}
+	return w
+}
+
+// encryptTransport and presharedKey configure the optional end-to-end
+// encrypted transport set up by -encrypt/-psk in main. When enabled, every
+// accepted connection must complete the handshake before any other message
+// is read from it — there is no fallback to plaintext.
+var (
+	encryptTransport bool
+	presharedKey     string
+)
+
+// streams coordinates the multiple independent connections that make up a
+// single parallel, multi-stream transfer; shared across every handleClient
+// goroutine.
+var streams = transfer.NewCoordinator()
+
+// resumeChunkSize is the unit progress is checkpointed at on disk; it must
+// match the block size the client uses so resume offsets line up.
+const resumeChunkSize = 1 << 20 // 1 MiB
+
+// partState is the sidecar bookkeeping kept next to a partially-received
+// file so an interrupted PUT can be resumed by any later connection.
+type partState struct {
+	Offset    uint64 `json:"offset"`
+	BlockSize uint64 `json:"blockSize"`
+}
+
+func loadPartState(path string) (*partState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ps partState
+	if err := json.Unmarshal(data, &ps); err != nil {
+		return nil, err
+	}
+	return &ps, nil
+}
+
+func (ps *partState) save(path string) error {
+	data, err := json.Marshal(ps)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// rehashPrefix re-derives a hash.Hash's state for the first n bytes of path
+// by reading them back from disk, rather than depending on the algorithm
+// supporting encoding.BinaryMarshaler/BinaryUnmarshaler — BLAKE3 and XXH3
+// don't, so resuming with either of those would otherwise silently fail to
+// restore the checksum and leave the file stuck unresumable forever.
+func rehashPrefix(path string, n uint64, algo hashalgo.Algo) (hash.Hash, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h, err := hashalgo.New(algo)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(h, f, int64(n)); err != nil {
+		return nil, fmt.Errorf("reading first %d bytes of %q: %w", n, path, err)
+	}
+	return h, nil
+}
+
+func handleResume(msgHandler *messages.MessageHandler, request *messages.ResumeRequest) error {
+	fileName := sanitizeName(request.FileName)
+
+	ps, err := loadPartState(fileName + ".part")
+	if err != nil {
+		msgHandler.SendResumeResponse(false, 0, nil)
+		return nil
+	}
+
+	log.Printf("Resuming %q at offset %d", fileName, ps.Offset)
+	msgHandler.SendResumeResponse(true, ps.Offset, nil)
+	return nil
+}
+
+func handleStorage(msgHandler *messages.MessageHandler, request *messages.StorageRequest, connLimiter *ratelimit.Limiter) (err error) {
+	start := time.Now()
+	defer func() {
+		metrics.TransferDuration.Observe(time.Since(start).Seconds())
+		outcome := "success"
+		if err != nil {
+			outcome = "error"
+		}
+		metrics.TransfersTotal.WithLabelValues("put", outcome).Inc()
+	}()
+
+	fileName := sanitizeName(request.FileName)
+
+	algo := hashalgo.Algo(request.ChecksumAlgo)
+	log.Printf("Storing %q (%d bytes, offset %d, %s)", fileName, request.Size, request.Offset, algo)
+
+	partPath := fileName + ".part"
+
+	flags := os.O_CREATE | os.O_WRONLY
+	if request.Offset == 0 {
+		if _, err := os.Stat(partPath); err == nil {
+			// A .part sidecar exists but the client is starting over from
+			// scratch, so fileName is a stale partial transfer rather than
+			// unrelated user data — safe to clear instead of hard-failing
+			// on O_EXCL below.
+			os.Remove(fileName)
+			os.Remove(partPath)
+		}
+		flags |= os.O_EXCL
+	}
+	file, err := os.OpenFile(fileName, flags, 0666)
 	if err != nil {
 		msgHandler.SendResponse(false, err.Error())
-		return fmt.Errorf("open %q: %w", request.FileName, err)
+		return fmt.Errorf("open %q: %w", fileName, err)
 	}
 	defer file.Close()
 
+	h, err := hashalgo.New(algo)
+	if err != nil {
+		msgHandler.SendResponse(false, err.Error())
+		return err
+	}
+	if request.Offset > 0 {
+		ps, err := loadPartState(partPath)
+		if err != nil || ps.Offset != request.Offset {
+			msgHandler.SendResponse(false, "stale resume offset")
+			return fmt.Errorf("resume state mismatch for %q", fileName)
+		}
+		primed, err := rehashPrefix(fileName, request.Offset, algo)
+		if err != nil {
+			msgHandler.SendResponse(false, "corrupt resume state")
+			return fmt.Errorf("restoring hash state for %q: %w", fileName, err)
+		}
+		h = primed
+		if _, err := file.Seek(int64(request.Offset), io.SeekStart); err != nil {
+			msgHandler.SendResponse(false, err.Error())
+			return fmt.Errorf("seek to offset %d: %w", request.Offset, err)
+		}
+	}
+
 	msgHandler.SendResponse(true, "Ready for data")
 
-	h := md5.New()
-	if _, err := io.CopyN(io.MultiWriter(file, h), msgHandler, int64(request.Size)); err != nil {
-		os.Remove(request.FileName) // don't leave a partial file
-		return fmt.Errorf("receiving data: %w", err)
+	remaining := request.Size - request.Offset
+	received := request.Offset
+	var blockIdx uint32
+	for remaining > 0 {
+		n := uint64(resumeChunkSize)
+		if remaining < n {
+			n = remaining
+		}
+
+		blockHash, err := hashalgo.New(algo)
+		if err != nil {
+			return err
+		}
+		if _, err := ratelimit.CopyNBuffer(io.MultiWriter(file, h, blockHash), rateLimitedReader(msgHandler, connLimiter), int64(n)); err != nil {
+			return fmt.Errorf("receiving data: %w", err)
+		}
+
+		idx, clientBlockSum, err := msgHandler.ReceiveBlockChecksum()
+		if err != nil {
+			return fmt.Errorf("receiving block checksum: %w", err)
+		}
+		if !util.VerifyChecksum(blockHash.Sum(nil), clientBlockSum) {
+			metrics.ChecksumFailures.WithLabelValues("put").Inc()
+			msgHandler.SendResponse(false, fmt.Sprintf("block %d checksum mismatch", idx))
+			return fmt.Errorf("block %d of %q failed checksum verification — transfer aborted early", idx, fileName)
+		}
+		msgHandler.SendResponse(true, "Block verified")
+		blockIdx++
+
+		received += n
+		remaining -= n
+
+		state := &partState{Offset: received, BlockSize: resumeChunkSize}
+		state.save(partPath)
 	}
 
 	clientCheckMsg, err := msgHandler.Receive()
 	if err != nil {
-		os.Remove(request.FileName)
 		return fmt.Errorf("receiving checksum: %w", err)
 	}
 
 	clientCheck := clientCheckMsg.GetChecksum().Checksum
 	if !util.VerifyChecksum(h.Sum(nil), clientCheck) {
-		os.Remove(request.FileName)
+		metrics.ChecksumFailures.WithLabelValues("put").Inc()
+		os.Remove(fileName)
+		os.Remove(partPath)
 		msgHandler.SendResponse(false, "Checksum mismatch")
 		return errors.New("checksum mismatch — file removed")
 	}
 
+	os.Remove(partPath)
+	metrics.TransferSize.Observe(float64(request.Size))
 	msgHandler.SendResponse(true, "File stored successfully")
-	log.Printf("Stored %q successfully", request.FileName)
+	log.Printf("Stored %q successfully", fileName)
 	return nil
 }
 
-func handleRetrieval(msgHandler *messages.MessageHandler, request *messages.RetrievalRequest) error {
-	log.Printf("Retrieving %q for %s", request.FileName, msgHandler.RemoteAddr())
+func handleRetrieval(msgHandler *messages.MessageHandler, request *messages.RetrievalRequest, connLimiter *ratelimit.Limiter) (err error) {
+	start := time.Now()
+	defer func() {
+		metrics.TransferDuration.Observe(time.Since(start).Seconds())
+		outcome := "success"
+		if err != nil {
+			outcome = "error"
+		}
+		metrics.TransfersTotal.WithLabelValues("get", outcome).Inc()
+	}()
+
+	log.Printf("Retrieving %q from offset %d", request.FileName, request.Offset)
 
 	info, err := os.Stat(request.FileName)
 	if err != nil {
@@ -64,69 +286,448 @@ func handleRetrieval(msgHandler *messages.MessageHandler, request *messages.Retr
 	}
 	defer file.Close()
 
+	if request.Offset > 0 {
+		if _, err := file.Seek(int64(request.Offset), io.SeekStart); err != nil {
+			msgHandler.SendRetrievalResponse(false, err.Error(), 0)
+			return fmt.Errorf("seek %q to offset %d: %w", request.FileName, request.Offset, err)
+		}
+	}
+
 	msgHandler.SendRetrievalResponse(true, "Ready to send", uint64(info.Size()))
 
-	h := md5.New()
-	if _, err := io.Copy(io.MultiWriter(msgHandler, h), file); err != nil {
-		return fmt.Errorf("sending data: %w", err)
+	if request.Length > 0 {
+		// A single range of a parallel, multi-stream retrieval: no
+		// whole-file checksum, but the range is still hashed so the client
+		// can verify each range it reassembles, the same way putStream
+		// verifies each range it sends.
+		rangeHash := md5.New()
+		if _, err := ratelimit.CopyNBuffer(io.MultiWriter(rateLimitedWriter(msgHandler, connLimiter), rangeHash), file, int64(request.Length)); err != nil {
+			return fmt.Errorf("sending range: %w", err)
+		}
+		msgHandler.SendBlockChecksum(0, rangeHash.Sum(nil))
+		log.Printf("Sent range [%d,%d) of %q", request.Offset, request.Offset+request.Length, request.FileName)
+		return nil
+	}
+
+	algo := hashalgo.Algo(request.ChecksumAlgo)
+	h, err := hashalgo.New(algo)
+	if err != nil {
+		return err
+	}
+
+	remaining := uint64(info.Size()) - request.Offset
+	var blockIdx uint32
+	for remaining > 0 {
+		n := uint64(resumeChunkSize)
+		if remaining < n {
+			n = remaining
+		}
+
+		blockHash, err := hashalgo.New(algo)
+		if err != nil {
+			return err
+		}
+		if _, err := ratelimit.CopyNBuffer(io.MultiWriter(rateLimitedWriter(msgHandler, connLimiter), h, blockHash), file, int64(n)); err != nil {
+			return fmt.Errorf("sending data: %w", err)
+		}
+		msgHandler.SendBlockChecksum(blockIdx, blockHash.Sum(nil))
+		blockIdx++
+		remaining -= n
 	}
 
 	msgHandler.SendChecksumVerification(h.Sum(nil))
+	metrics.TransferSize.Observe(float64(info.Size()))
 	log.Printf("Sent %q successfully", request.FileName)
 	return nil
 }
 
-func handleClient(msgHandler *messages.MessageHandler) {
+// handleStreamAssignment receives one contiguous byte range belonging to a
+// larger parallel PUT and writes it directly into the target file at its
+// assigned offset. The first stream to arrive for a given transferID
+// preallocates the file and every other stream waits for that to finish
+// before opening it, so none of them can race ahead of the preallocation.
+// Once every stream for the transfer has landed and passed its own
+// range checksum, whichever one happens to be last re-reads the completed
+// file once, hashes it, and that single whole-transfer checksum is handed
+// back to every stream to relay to its own client connection.
+func handleStreamAssignment(msgHandler *messages.MessageHandler, request *messages.StreamAssignment) error {
+	fileName := sanitizeName(request.FileName)
+
+	isFirst, ready := streams.Begin(request.TransferId, int(request.NumStreams))
+	if isFirst {
+		setupErr := func() error {
+			file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY, 0666)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			return file.Truncate(int64(request.Size))
+		}()
+		streams.MarkReady(request.TransferId)
+		if setupErr != nil {
+			msgHandler.SendResponse(false, setupErr.Error())
+			streams.Finish(request.TransferId, false, nil)
+			return fmt.Errorf("preallocating %q: %w", fileName, setupErr)
+		}
+	} else {
+		<-ready
+	}
+
+	msgHandler.SendResponse(true, "Ready for stream")
+
+	file, err := os.OpenFile(fileName, os.O_WRONLY, 0666)
+	if err != nil {
+		msgHandler.SendResponse(false, err.Error())
+		streams.Finish(request.TransferId, false, nil)
+		return fmt.Errorf("open %q for stream %d: %w", fileName, request.StreamIdx, err)
+	}
+
+	buf := make([]byte, request.Length)
+	if _, err := io.ReadFull(msgHandler, buf); err != nil {
+		file.Close()
+		msgHandler.SendResponse(false, err.Error())
+		streams.Finish(request.TransferId, false, nil)
+		return fmt.Errorf("receiving stream %d: %w", request.StreamIdx, err)
+	}
+
+	if _, err := file.WriteAt(buf, int64(request.Offset)); err != nil {
+		file.Close()
+		msgHandler.SendResponse(false, err.Error())
+		streams.Finish(request.TransferId, false, nil)
+		return fmt.Errorf("writing stream %d: %w", request.StreamIdx, err)
+	}
+	file.Close()
+
+	rangeSum := md5.Sum(buf)
+	idx, clientSum, err := msgHandler.ReceiveBlockChecksum()
+	if err != nil {
+		streams.Finish(request.TransferId, false, nil)
+		return fmt.Errorf("receiving checksum for stream %d: %w", request.StreamIdx, err)
+	}
+	if !util.VerifyChecksum(rangeSum[:], clientSum) {
+		metrics.ChecksumFailures.WithLabelValues("put").Inc()
+		msgHandler.SendResponse(false, fmt.Sprintf("stream %d checksum mismatch", idx))
+		streams.Finish(request.TransferId, false, nil)
+		return fmt.Errorf("stream %d of %q failed checksum verification", idx, fileName)
+	}
+	msgHandler.SendResponse(true, "Stream stored")
+
+	sum, err := streams.Finish(request.TransferId, true, func() ([]byte, error) {
+		return fileMD5(fileName)
+	})
+	if err != nil {
+		msgHandler.SendChecksumVerification(nil)
+		return fmt.Errorf("transfer %s (%q): %w", request.TransferId, fileName, err)
+	}
+
+	msgHandler.SendChecksumVerification(sum)
+	log.Printf("All %d streams landed for transfer %s (%q) — whole-file checksum verified", request.NumStreams, request.TransferId, fileName)
+	return nil
+}
+
+// sanitizeName strips any directory components a client sent in a
+// FileName/RootName/DirName field, keeping only the final path element, so a
+// request like "../../etc/passwd" or "/etc/passwd" resolves to "passwd"
+// inside the (already chdir'd) storage root rather than escaping it.
+func sanitizeName(name string) string {
+	parts := strings.Split(name, "/")
+	return parts[len(parts)-1]
+}
+
+// sanitizeTarPath joins a tar entry name onto destDir, rejecting absolute
+// paths and ".." components so a malicious or corrupt archive can't write
+// outside the destination.
+func sanitizeTarPath(destDir, name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return "", fmt.Errorf("unsafe absolute path in archive: %q", name)
+	}
+	clean := filepath.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("unsafe path in archive: %q", name)
+	}
+	return filepath.Join(destDir, clean), nil
+}
+
+// fileMD5 hashes a single file on disk; used to annotate each tar entry
+// with a per-file checksum the other side can verify independently.
+func fileMD5(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := ratelimit.CopyBuffer(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// writeTar walks root and writes its contents to tw, rooted under
+// filepath.Base(root) so the receiver extracts a single top-level
+// directory. Every regular file's MD5 is recorded in its PAX header.
+func writeTar(tw *tar.Writer, root string) error {
+	rootName := filepath.Base(root)
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			header.Name = rootName + "/"
+		} else {
+			header.Name = filepath.ToSlash(filepath.Join(rootName, rel))
+			if info.IsDir() {
+				header.Name += "/"
+			}
+		}
+
+		if info.Mode().IsRegular() {
+			sum, err := fileMD5(path)
+			if err != nil {
+				return err
+			}
+			header.PAXRecords = map[string]string{"file-transfer.md5": hex.EncodeToString(sum)}
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err := ratelimit.CopyBuffer(tw, f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// handleDirectoryStorage extracts an incoming tar stream under the current
+// directory, rejecting any entry that would escape it.
+func handleDirectoryStorage(msgHandler *messages.MessageHandler, request *messages.DirectoryStorageRequest) error {
+	rootName := sanitizeName(request.RootName)
+	log.Printf("Storing directory %q (%d bytes)", rootName, request.Size)
+
+	msgHandler.SendResponse(true, "Ready for data")
+
+	tr := tar.NewReader(io.LimitReader(msgHandler, int64(request.Size)))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			msgHandler.SendResponse(false, err.Error())
+			return fmt.Errorf("reading tar stream: %w", err)
+		}
+
+		target, err := sanitizeTarPath(".", header.Name)
+		if err != nil {
+			msgHandler.SendResponse(false, err.Error())
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				msgHandler.SendResponse(false, err.Error())
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				msgHandler.SendResponse(false, err.Error())
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+			if err != nil {
+				msgHandler.SendResponse(false, err.Error())
+				return err
+			}
+			h := md5.New()
+			_, copyErr := ratelimit.CopyBuffer(io.MultiWriter(f, h), tr)
+			f.Close()
+			if copyErr != nil {
+				msgHandler.SendResponse(false, copyErr.Error())
+				return fmt.Errorf("writing %q: %w", target, copyErr)
+			}
+			if want, ok := header.PAXRecords["file-transfer.md5"]; ok && hex.EncodeToString(h.Sum(nil)) != want {
+				metrics.ChecksumFailures.WithLabelValues("put_dir").Inc()
+				msgHandler.SendResponse(false, "checksum mismatch")
+				return fmt.Errorf("checksum mismatch for %q — corrupt transfer", header.Name)
+			}
+		}
+	}
+
+	msgHandler.SendResponse(true, "Directory stored successfully")
+	log.Printf("Stored directory %q successfully", rootName)
+	return nil
+}
+
+// handleDirectoryRetrieval streams a directory on disk back to the client as
+// a tar archive.
+func handleDirectoryRetrieval(msgHandler *messages.MessageHandler, request *messages.DirectoryRetrievalRequest) error {
+	dirName := sanitizeName(request.DirName)
+	log.Printf("Retrieving directory %q", dirName)
+
+	info, err := os.Stat(dirName)
+	if err != nil || !info.IsDir() {
+		msgHandler.SendDirectoryRetrievalResponse(false, "not a directory", 0)
+		return fmt.Errorf("stat directory %q: %w", dirName, err)
+	}
+
+	var counter countingWriter
+	tw := tar.NewWriter(&counter)
+	if err := writeTar(tw, dirName); err != nil {
+		msgHandler.SendDirectoryRetrievalResponse(false, err.Error(), 0)
+		return fmt.Errorf("sizing directory %q: %w", dirName, err)
+	}
+	tw.Close()
+
+	msgHandler.SendDirectoryRetrievalResponse(true, "Ready to send", counter.n)
+
+	tw = tar.NewWriter(msgHandler)
+	if err := writeTar(tw, dirName); err != nil {
+		return fmt.Errorf("streaming directory %q: %w", dirName, err)
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("closing tar stream: %w", err)
+	}
+
+	log.Printf("Sent directory %q successfully", dirName)
+	return nil
+}
+
+// countingWriter discards everything written to it, counting the bytes;
+// used to size a tar stream before committing to the DirectoryRetrievalResponse.
+type countingWriter struct{ n uint64 }
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += uint64(len(p))
+	return len(p), nil
+}
+
+func handleClient(msgHandler *messages.MessageHandler, connLimiter *ratelimit.Limiter) {
 	defer msgHandler.Close()
-	addr := msgHandler.RemoteAddr()
-	log.Println("Handling client", addr)
+	log.Println("Handling new client connection")
+
+	metrics.ActiveConnections.Inc()
+	defer metrics.ActiveConnections.Dec()
 
 	for {
 		wrapper, err := msgHandler.Receive()
 		if err != nil {
-			// EOF means the client closed the connection cleanly
 			if errors.Is(err, io.EOF) {
-				log.Println("Client disconnected:", addr)
+				log.Println("Client disconnected")
 			} else {
-				log.Println("Receive error from", addr, ":", err)
+				log.Println("Receive error:", err)
 			}
 			return
 		}
 
 		switch msg := wrapper.Msg.(type) {
 		case *messages.Wrapper_StorageReq:
-			if err := handleStorage(msgHandler, msg.StorageReq); err != nil {
-				log.Printf("Storage error for %s: %v", addr, err)
+			if err := handleStorage(msgHandler, msg.StorageReq, connLimiter); err != nil {
+				log.Printf("Storage error: %v", err)
 			}
 		case *messages.Wrapper_RetrievalReq:
-			if err := handleRetrieval(msgHandler, msg.RetrievalReq); err != nil {
-				log.Printf("Retrieval error for %s: %v", addr, err)
+			if err := handleRetrieval(msgHandler, msg.RetrievalReq, connLimiter); err != nil {
+				log.Printf("Retrieval error: %v", err)
+			}
+		case *messages.Wrapper_ResumeReq:
+			if err := handleResume(msgHandler, msg.ResumeReq); err != nil {
+				log.Printf("Resume error: %v", err)
+			}
+		case *messages.Wrapper_StreamAssignment:
+			if err := handleStreamAssignment(msgHandler, msg.StreamAssignment); err != nil {
+				log.Printf("Stream assignment error: %v", err)
+			}
+		case *messages.Wrapper_DirectoryStorageReq:
+			if err := handleDirectoryStorage(msgHandler, msg.DirectoryStorageReq); err != nil {
+				log.Printf("Directory storage error: %v", err)
+			}
+		case *messages.Wrapper_DirectoryRetrievalReq:
+			if err := handleDirectoryRetrieval(msgHandler, msg.DirectoryRetrievalReq); err != nil {
+				log.Printf("Directory retrieval error: %v", err)
 			}
 		case nil:
-			log.Println("Empty message from", addr, "— closing connection")
+			log.Println("Empty message — closing connection")
 			return
 		default:
-			log.Printf("Unexpected message type %T from %s", msg, addr)
+			log.Printf("Unexpected message type: %T", msg)
 		}
 	}
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: %s port [storage-dir]\n", os.Args[0])
+	metricsAddr := flag.String("metrics", "", "address to serve Prometheus metrics on, e.g. :9090 (disabled if empty)")
+	statsdAddr := flag.String("statsd", "", "host:port of a StatsD daemon to emit metrics to (disabled if empty)")
+	encrypt := flag.Bool("encrypt", false, "require clients to negotiate an end-to-end encrypted transport")
+	psk := flag.String("psk", "", "pre-shared key mixed into the encrypted handshake to resist MITM (requires -encrypt)")
+	rateFlag := flag.String("rate", "", "cap aggregate transfer bandwidth across all clients, e.g. 10MB or 500KB (bytes/sec; unlimited if empty)")
+	connRateFlag := flag.String("conn-rate", "", "additionally cap each individual connection's bandwidth, e.g. 1MB (bytes/sec; unlimited if empty)")
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-metrics addr] [-statsd host:port] [-encrypt] [-psk key] [-rate bytes/sec] [-conn-rate bytes/sec] port [storage-dir]\n", os.Args[0])
 		os.Exit(1)
 	}
 
-	port := os.Args[1]
+	encryptTransport = *encrypt
+	presharedKey = *psk
+	if presharedKey != "" && !encryptTransport {
+		log.Fatalf("-psk requires -encrypt\n")
+	}
+	if *rateFlag != "" {
+		bytesPerSec, err := ratelimit.ParseRate(*rateFlag)
+		if err != nil {
+			log.Fatalf("Invalid -rate value: %v\n", err)
+		}
+		globalLimiter = ratelimit.NewLimiter(bytesPerSec)
+	}
+	if *connRateFlag != "" {
+		bytesPerSec, err := ratelimit.ParseRate(*connRateFlag)
+		if err != nil {
+			log.Fatalf("Invalid -conn-rate value: %v\n", err)
+		}
+		connRateBytesPerSec = bytesPerSec
+	}
+
+	port := args[0]
 
 	dir := "."
-	if len(os.Args) >= 3 {
-		dir = os.Args[2]
+	if len(args) >= 2 {
+		dir = args[1]
 	}
 	if err := os.Chdir(dir); err != nil {
 		log.Fatalf("Cannot chdir to %q: %v\n", dir, err)
 	}
 
+	if *metricsAddr != "" {
+		metrics.ServeHTTP(*metricsAddr)
+	}
+	if *statsdAddr != "" {
+		client, err := metrics.NewStatsDClient(*statsdAddr, "file_transfer")
+		if err != nil {
+			log.Fatalf("Connecting to statsd at %s failed: %v\n", *statsdAddr, err)
+		}
+		statsd = client
+	}
+
 	listener, err := net.Listen("tcp", ":"+port)
 	if err != nil {
 		log.Fatalf("Listen on port %s failed: %v\n", port, err)
@@ -138,7 +739,6 @@ func main() {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			// Distinguish between a closed listener and a transient error
 			if errors.Is(err, net.ErrClosed) {
 				log.Println("Listener closed, shutting down")
 				return
@@ -147,6 +747,27 @@ func main() {
 			continue
 		}
 		log.Println("Accepted connection from", conn.RemoteAddr())
-		go handleClient(messages.NewMessageHandler(conn))
+		go acceptClient(conn)
 	}
-}
\ No newline at end of file
+}
+
+// acceptClient completes the optional encryption handshake for a freshly
+// accepted connection, then hands it off to handleClient.
+func acceptClient(conn net.Conn) {
+	if encryptTransport {
+		encConn, err := crypt.ServerHandshake(conn, presharedKey)
+		if err != nil {
+			log.Printf("Encryption handshake with %s failed: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			return
+		}
+		conn = encConn
+	}
+
+	var connLimiter *ratelimit.Limiter
+	if connRateBytesPerSec > 0 {
+		connLimiter = ratelimit.NewLimiter(connRateBytesPerSec)
+	}
+
+	handleClient(messages.NewMessageHandler(metrics.WrapConn(conn, statsd)), connLimiter)
+}
@@ -0,0 +1,151 @@
+// Package transfer tracks the state of multi-stream transfers that span
+// more than one TCP connection, so the server can tell when every stream
+// assigned to a transferID has landed.
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// transferTimeout bounds how long Finish will wait for *another* stream of a
+// transfer to report in before giving up. It's measured from the last time
+// any stream reported progress, not from when waiting started, so a
+// transfer with several large or rate-limited streams that are each still
+// legitimately landing one at a time is never penalized for simply taking
+// longer than transferTimeout in total — only a transfer where nothing has
+// reported in for a full transferTimeout window (most likely a stream that
+// never reached the server at all: client-side dial failure, connection
+// reset before its StreamAssignment lands, …) times out.
+var transferTimeout = 30 * time.Second
+
+type streamState struct {
+	numStreams  int
+	streamsDone int
+	failed      bool
+	ready       chan struct{} // closed once the stream that owns setup has finished (or failed) it
+	done        chan struct{} // closed once every stream has reported in and sum/sumErr are set
+	sum         []byte
+	sumErr      error
+	lastReport  time.Time // updated each time a stream calls Finish, so idle-time can be measured
+}
+
+// Coordinator is a mutex-guarded registry of in-flight multi-stream
+// transfers, keyed by transferID. A single Coordinator is shared across all
+// of a server's handleClient goroutines.
+type Coordinator struct {
+	mu        sync.Mutex
+	transfers map[string]*streamState
+}
+
+func NewCoordinator() *Coordinator {
+	return &Coordinator{transfers: make(map[string]*streamState)}
+}
+
+// Begin registers a new transfer if transferID is not yet known. The first
+// caller for a given transferID gets isFirst=true and owns one-time setup
+// (e.g. truncating the destination file to its final size); every other
+// caller gets isFirst=false and must wait on the returned ready channel
+// before touching the file, so a later stream can never race ahead of the
+// first stream's setup. Whichever caller got isFirst=true must call
+// MarkReady exactly once, whether or not its setup succeeded.
+func (c *Coordinator) Begin(transferID string, numStreams int) (isFirst bool, ready <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if state, exists := c.transfers[transferID]; exists {
+		return false, state.ready
+	}
+	state := &streamState{
+		numStreams: numStreams,
+		ready:      make(chan struct{}),
+		done:       make(chan struct{}),
+		lastReport: time.Now(),
+	}
+	c.transfers[transferID] = state
+	return true, state.ready
+}
+
+// MarkReady unblocks every stream waiting on the ready channel Begin
+// returned for transferID.
+func (c *Coordinator) MarkReady(transferID string) {
+	c.mu.Lock()
+	state, exists := c.transfers[transferID]
+	c.mu.Unlock()
+	if exists {
+		close(state.ready)
+	}
+}
+
+// Finish records that one stream of transferID finished, successfully or
+// not, then blocks until every stream has reported in. The last stream to
+// report computes the whole-transfer checksum via compute (skipped, with an
+// error result, if any stream reported failure) and shares it with every
+// other caller waiting here for the same transferID — so compute runs
+// exactly once per transfer no matter how many streams call Finish.
+func (c *Coordinator) Finish(transferID string, ok bool, compute func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	state, exists := c.transfers[transferID]
+	if !exists {
+		c.mu.Unlock()
+		return nil, errors.New("unknown transfer")
+	}
+	if !ok {
+		state.failed = true
+	}
+	state.streamsDone++
+	state.lastReport = time.Now()
+	last := state.streamsDone >= state.numStreams
+	if last {
+		delete(c.transfers, transferID)
+	}
+	c.mu.Unlock()
+
+	if last {
+		if state.failed {
+			state.sumErr = errors.New("one or more streams failed")
+		} else {
+			state.sum, state.sumErr = compute()
+		}
+		close(state.done)
+		return state.sum, state.sumErr
+	}
+
+	for {
+		timer := time.NewTimer(transferTimeout)
+		select {
+		case <-state.done:
+			timer.Stop()
+			return state.sum, state.sumErr
+		case <-timer.C:
+		}
+
+		// A stream hasn't finished in transferTimeout, but check whether
+		// some *other* stream reported progress more recently than that —
+		// a transfer with several large or rate-limited streams can easily
+		// take longer than transferTimeout in total without ever going
+		// idle, and such a transfer shouldn't be killed just for being
+		// slow. Only a transfer where nothing has reported in for a full
+		// transferTimeout window gets timed out.
+		c.mu.Lock()
+		if _, stillRegistered := c.transfers[transferID]; !stillRegistered {
+			// Another waiter already timed it out (or it finished and was
+			// removed) between our timer firing and this lock.
+			c.mu.Unlock()
+			select {
+			case <-state.done:
+				return state.sum, state.sumErr
+			default:
+				return nil, fmt.Errorf("transfer %s: timed out waiting for all %d streams", transferID, state.numStreams)
+			}
+		}
+		if idle := time.Since(state.lastReport); idle < transferTimeout {
+			c.mu.Unlock()
+			continue
+		}
+		delete(c.transfers, transferID)
+		c.mu.Unlock()
+		return nil, fmt.Errorf("transfer %s: timed out after %s waiting for all %d streams", transferID, transferTimeout, state.numStreams)
+	}
+}
@@ -0,0 +1,171 @@
+package transfer
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCoordinatorFirstStreamGatesOthers(t *testing.T) {
+	c := NewCoordinator()
+
+	isFirst, ready := c.Begin("t1", 2)
+	if !isFirst {
+		t.Fatalf("first Begin: isFirst = false, want true")
+	}
+
+	isFirst2, ready2 := c.Begin("t1", 2)
+	if isFirst2 {
+		t.Fatalf("second Begin: isFirst = true, want false")
+	}
+
+	select {
+	case <-ready2:
+		t.Fatalf("ready2 closed before MarkReady was called")
+	default:
+	}
+
+	c.MarkReady("t1")
+
+	select {
+	case <-ready2:
+	case <-time.After(time.Second):
+		t.Fatalf("ready2 never closed after MarkReady")
+	}
+	select {
+	case <-ready:
+	case <-time.After(time.Second):
+		t.Fatalf("ready never closed after MarkReady")
+	}
+}
+
+func TestCoordinatorFinishComputesOnce(t *testing.T) {
+	c := NewCoordinator()
+	c.Begin("t2", 3)
+	c.MarkReady("t2")
+
+	var calls int32
+	compute := func() ([]byte, error) {
+		calls++
+		return []byte("sum"), nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([][]byte, 3)
+	errs := make([]error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = c.Finish("t2", true, compute)
+		}(i)
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("compute called %d times, want 1", calls)
+	}
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("Finish[%d]: unexpected error: %v", i, err)
+		}
+		if string(results[i]) != "sum" {
+			t.Fatalf("Finish[%d] = %q, want %q", i, results[i], "sum")
+		}
+	}
+}
+
+func TestCoordinatorFinishReportsFailure(t *testing.T) {
+	c := NewCoordinator()
+	c.Begin("t3", 2)
+	c.MarkReady("t3")
+
+	computeCalled := false
+	compute := func() ([]byte, error) {
+		computeCalled = true
+		return []byte("sum"), nil
+	}
+
+	go c.Finish("t3", false, compute)
+	_, err := c.Finish("t3", true, compute)
+	if err == nil {
+		t.Fatalf("Finish: expected error after a failed stream, got nil")
+	}
+	if computeCalled {
+		t.Fatalf("compute should not run when any stream reported failure")
+	}
+}
+
+func TestCoordinatorFinishTimesOutIfAStreamNeverArrives(t *testing.T) {
+	old := transferTimeout
+	transferTimeout = 50 * time.Millisecond
+	defer func() { transferTimeout = old }()
+
+	c := NewCoordinator()
+	c.Begin("t4", 2) // a second stream is expected but never calls Finish
+
+	done := make(chan struct{})
+	var err error
+	go func() {
+		_, err = c.Finish("t4", true, func() ([]byte, error) { return []byte("sum"), nil })
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Finish blocked past its timeout instead of returning an error")
+	}
+	if err == nil {
+		t.Fatalf("Finish: expected a timeout error, got nil")
+	}
+
+	// The abandoned transfer should have been cleared, not left registered
+	// forever.
+	isFirst, _ := c.Begin("t4", 2)
+	if !isFirst {
+		t.Fatalf("Begin(%q) after timeout: isFirst = false, want true (transfer should have been cleared)", "t4")
+	}
+}
+
+func TestCoordinatorFinishDoesNotTimeOutOnSlowButProgressingStreams(t *testing.T) {
+	old := transferTimeout
+	transferTimeout = 80 * time.Millisecond
+	defer func() { transferTimeout = old }()
+
+	c := NewCoordinator()
+	c.Begin("t5", 3)
+
+	compute := func() ([]byte, error) { return []byte("sum"), nil }
+
+	errs := make(chan error, 3)
+	// Three streams report in staggered, each comfortably inside
+	// transferTimeout of the previous one, but the whole sequence spans
+	// well past a single transferTimeout window from when the first
+	// stream started waiting.
+	go func() {
+		_, err := c.Finish("t5", true, compute)
+		errs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	go func() {
+		_, err := c.Finish("t5", true, compute)
+		errs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	_, lastErr := c.Finish("t5", true, compute)
+	if lastErr != nil {
+		t.Fatalf("last Finish: unexpected error: %v", lastErr)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Fatalf("Finish[%d]: unexpected error: %v", i, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Finish[%d] never returned", i)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Package hash resolves the checksum algorithm negotiated between client
+// and server into a concrete hash.Hash, so the rest of the codebase never
+// has to know about any algorithm but the one it was asked for.
+package hash
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	stdhash "hash"
+
+	"github.com/zeebo/xxh3"
+	"lukechampine.com/blake3"
+)
+
+// Algo identifies a checksum algorithm. It travels over the wire as part of
+// the initial StorageRequest/RetrievalRequest so both ends agree on how a
+// transfer will be verified before any bytes move.
+type Algo int32
+
+const (
+	MD5 Algo = iota
+	SHA256
+	BLAKE3
+	XXH3
+)
+
+func (a Algo) String() string {
+	switch a {
+	case MD5:
+		return "MD5"
+	case SHA256:
+		return "SHA256"
+	case BLAKE3:
+		return "BLAKE3"
+	case XXH3:
+		return "XXH3"
+	default:
+		return fmt.Sprintf("Algo(%d)", int32(a))
+	}
+}
+
+// ParseAlgo maps a user-facing name (as accepted by the -hash flag) to an
+// Algo, case-insensitively.
+func ParseAlgo(name string) (Algo, error) {
+	switch name {
+	case "md5", "MD5":
+		return MD5, nil
+	case "sha256", "SHA256":
+		return SHA256, nil
+	case "blake3", "BLAKE3":
+		return BLAKE3, nil
+	case "xxh3", "XXH3":
+		return XXH3, nil
+	default:
+		return 0, fmt.Errorf("unknown checksum algorithm %q", name)
+	}
+}
+
+// New returns a fresh hash.Hash for the given algorithm.
+func New(a Algo) (stdhash.Hash, error) {
+	switch a {
+	case MD5:
+		return md5.New(), nil
+	case SHA256:
+		return sha256.New(), nil
+	case BLAKE3:
+		return blake3.New(32, nil), nil
+	case XXH3:
+		return xxh3.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown checksum algorithm %v", a)
+	}
+}